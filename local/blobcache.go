@@ -0,0 +1,264 @@
+package local
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/drud/drud-go/utils"
+)
+
+// archiveManifest records the last archive GetResources pulled for a given
+// (app, environment), so subsequent runs can skip re-downloading content
+// they already have a verified local copy of.
+type archiveManifest struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag"`
+}
+
+// blobsDir returns ~/.drud/blobs/sha256, creating it if necessary.
+func blobsDir() (string, error) {
+	homedir, err := utils.GetHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(homedir, ".drud", "blobs", "sha256")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// manifestsDir returns ~/.drud/manifests, creating it if necessary.
+func manifestsDir() (string, error) {
+	homedir, err := utils.GetHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(homedir, ".drud", "manifests")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+func manifestPath(name, environment string) (string, error) {
+	dir, err := manifestsDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.json", name, environment)), nil
+}
+
+func readManifest(name, environment string) (*archiveManifest, error) {
+	p, err := manifestPath(name, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var m archiveManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func writeManifest(name, environment string, m archiveManifest) error {
+	p, err := manifestPath(name, environment)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, data, 0644)
+}
+
+// digestFile returns the hex sha256 digest of the file at path.
+func digestFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// md5File returns the hex MD5 digest of the file at path, for comparison
+// against the bare-MD5 ETags S3-compatible backends return.
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// md5ETagPattern matches a bare hex MD5, the form S3 (and most
+// S3-compatible backends) return as the ETag of a non-multipart object.
+// Multipart ETags look like "<hex>-<partcount>" and aren't a content hash,
+// so they're not useful for integrity verification here.
+var md5ETagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// fetchArchiveBlob resolves the archive for (name, environment) identified
+// by remoteKey/remoteETag/remoteSize to a path in the content-addressable
+// blob store, downloading and verifying it through backend only when the
+// cached copy is missing, stale, or has been tampered with on disk.
+//
+// The download itself is checked against remoteSize and, when remoteETag
+// looks like a bare MD5, against an MD5 computed while streaming — so a
+// download truncated or corrupted in transit is rejected rather than
+// cached under a digest that only describes the corrupted bytes.
+func fetchArchiveBlob(backend archiveDownloader, name, environment, remoteKey, remoteETag string, remoteSize int64) (string, error) {
+	dir, err := blobsDir()
+	if err != nil {
+		return "", err
+	}
+
+	if manifest, err := readManifest(name, environment); err == nil && manifest.ETag == remoteETag {
+		blob := filepath.Join(dir, manifest.Digest)
+		if digest, err := digestFile(blob); err == nil && digest == manifest.Digest {
+			log.Printf("Using cached archive %s for %s-%s", blob, name, environment)
+			return blob, nil
+		}
+		log.Printf("Cached archive for %s-%s failed verification, re-downloading", name, environment)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "download-")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	hasher := sha256.New()
+	md5Hasher := md5.New()
+	err = backend.Download(remoteKey, io.MultiWriter(tmp, hasher, md5Hasher))
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	info, err := tmp.Stat()
+	tmp.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if remoteSize > 0 && info.Size() != remoteSize {
+		return "", fmt.Errorf("downloaded archive %s is %d bytes, expected %d from %s", remoteKey, info.Size(), remoteSize, name)
+	}
+
+	if md5ETagPattern.MatchString(remoteETag) {
+		computed := fmt.Sprintf("%x", md5Hasher.Sum(nil))
+		if computed != remoteETag {
+			return "", fmt.Errorf("downloaded archive %s failed integrity check: etag %s, computed %s", remoteKey, remoteETag, computed)
+		}
+	}
+
+	digest := fmt.Sprintf("%x", hasher.Sum(nil))
+	blob := filepath.Join(dir, digest)
+	if err := os.Rename(tmpPath, blob); err != nil {
+		return "", err
+	}
+
+	if err := writeManifest(name, environment, archiveManifest{Digest: digest, Size: info.Size(), ETag: remoteETag}); err != nil {
+		return "", err
+	}
+
+	return blob, nil
+}
+
+// archiveDownloader is the subset of storage.Backend fetchArchiveBlob needs.
+type archiveDownloader interface {
+	Download(key string, w io.Writer) error
+}
+
+// PruneBlobs removes blobs in the content-addressable cache that are no
+// longer referenced by any app's manifest. Nothing in this package calls it;
+// it runs only when a caller invokes it explicitly.
+func PruneBlobs() error {
+	dir, err := blobsDir()
+	if err != nil {
+		return err
+	}
+
+	mdir, err := manifestsDir()
+	if err != nil {
+		return err
+	}
+
+	manifestFiles, err := ioutil.ReadDir(mdir)
+	if err != nil {
+		return err
+	}
+
+	referenced := map[string]bool{}
+	for _, f := range manifestFiles {
+		data, err := ioutil.ReadFile(filepath.Join(mdir, f.Name()))
+		if err != nil {
+			continue
+		}
+
+		var m archiveManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			continue
+		}
+
+		referenced[m.Digest] = true
+	}
+
+	blobFiles, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range blobFiles {
+		if referenced[f.Name()] {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+		log.Printf("Pruned unreferenced blob %s", f.Name())
+	}
+
+	return nil
+}
@@ -3,52 +3,67 @@ package local
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/fsouza/go-dockerclient"
 
 	"github.com/drud/bootstrap/cli/cms/config"
 	"github.com/drud/bootstrap/cli/cms/model"
 	"github.com/drud/drud-go/secrets"
 	"github.com/drud/drud-go/utils"
+
+	"github.com/drud/bootstrap/cli/local/portalloc"
+	"github.com/drud/bootstrap/cli/local/storage"
 )
 
 // LegacyApp implements the LocalApp interface for Legacy Newmedia apps
 type LegacyApp struct {
-	Name          string
-	Environment   string
-	AppType       string
-	Template      string
-	Branch        string
-	Repo          string
-	Archive       string //absolute path to the downloaded archive
-	WebPublicPort int64
-	DbPublicPort  int64
+	Name           string
+	Environment    string
+	AppType        string
+	Template       string
+	Branch         string
+	Repo           string
+	Archive        string //absolute path to the downloaded archive
+	WebPublicPort  int64
+	DbPublicPort   int64
+	StorageBackend storage.Backend // resolved from the databag's StorageBackend URI
 }
 
 // RenderComposeYAML returns teh contents of a docker compose config for this app
-func (l LegacyApp) RenderComposeYAML() (string, error) {
+func (l *LegacyApp) RenderComposeYAML() (string, error) {
 	var doc bytes.Buffer
 	var err error
+
+	l.WebPublicPort, err = portalloc.Allocate(l.ContainerName()+"-web", portalloc.WebRange.Min)
+	if err != nil {
+		return "", err
+	}
+
+	l.DbPublicPort, err = portalloc.Allocate(l.ContainerName()+"-db", portalloc.DBRange.Min)
+	if err != nil {
+		return "", err
+	}
+
 	templ := template.New("compose template")
 	templ, err = templ.Parse(l.Template)
 	if err != nil {
 		return "", err
 	}
 	templ.Execute(&doc, map[string]string{
-		"image": fmt.Sprintf("drud/nginx-php-fpm-%s", l.AppType),
-		"name":  l.ContainerName(),
+		"image":         fmt.Sprintf("drud/nginx-php-fpm-%s", l.AppType),
+		"name":          l.ContainerName(),
+		"webPublicPort": strconv.FormatInt(l.WebPublicPort, 10),
+		"dbPublicPort":  strconv.FormatInt(l.DbPublicPort, 10),
 	})
 	return doc.String(), nil
 }
@@ -112,8 +127,6 @@ func (l LegacyApp) DatabagExists() bool {
 
 // GetResources downloads external data for this app
 func (l *LegacyApp) GetResources() error {
-	basePath := l.AbsPath()
-
 	dbag, err := GetDatabag(l.Name)
 	if err != nil {
 		return err
@@ -123,10 +136,15 @@ func (l *LegacyApp) GetResources() error {
 	if err != nil {
 		return err
 	}
+	ResolveFileSecrets(&s)
 
-	bucket := "nmdarchive"
-	if s.AwsBucket != "" {
-		bucket = s.AwsBucket
+	backendURI := s.StorageBackend
+	if backendURI == "" {
+		bucket := "nmdarchive"
+		if s.AwsBucket != "" {
+			bucket = s.AwsBucket
+		}
+		backendURI = fmt.Sprintf("s3://%s", bucket)
 	}
 
 	awsID := s.AwsAccessKey
@@ -148,46 +166,39 @@ func (l *LegacyApp) GetResources() error {
 	os.Setenv("AWS_ACCESS_KEY_ID", awsID)
 	os.Setenv("AWS_SECRET_ACCESS_KEY", awsSecret)
 
-	svc := s3.New(session.New(&aws.Config{Region: aws.String("us-west-2")}))
-	prefix := fmt.Sprintf("%[1]s/%[2]s-%[1]s-", l.Name, l.Environment)
-
-	params := &s3.ListObjectsInput{
-		Bucket: aws.String(bucket),
-		Prefix: &prefix,
-	}
-
-	resp, err := svc.ListObjects(params)
+	backend, err := storage.New(backendURI)
 	if err != nil {
 		return err
 	}
+	l.StorageBackend = backend
 
-	archive := resp.Contents[len(resp.Contents)-1]
-	file, err := os.Create(path.Join(basePath, filepath.Base(*archive.Key)))
+	prefix := fmt.Sprintf("%[1]s/%[2]s-%[1]s-", l.Name, l.Environment)
+
+	objects, err := backend.List(prefix)
 	if err != nil {
-		log.Fatal("Failed to create file", err)
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no archives found matching %s in %s", prefix, backendURI)
 	}
-	defer file.Close()
 
-	downloader := s3manager.NewDownloader(session.New(&aws.Config{Region: aws.String("us-west-2")}))
-	numBytes, err := downloader.Download(
-		file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(*archive.Key),
-		},
-	)
+	archive := objects[len(objects)-1]
+
+	blob, err := fetchArchiveBlob(backend, l.Name, l.Environment, archive.Key, archive.ETag, archive.Size)
 	if err != nil {
 		return err
 	}
 
-	log.Println("Downloaded file", file.Name(), numBytes, "bytes")
-	l.Archive = file.Name()
+	log.Println("Archive", archive.Key, "resolved to blob", blob)
+	l.Archive = blob
 
 	return nil
 }
 
 // UnpackResources takes the archive from the GetResources method and
 // unarchives it. Then the contents are moved to their proper locations.
+// l.Archive now lives in the content-addressable blob cache shared across
+// apps and environments, so it is read in place rather than consumed.
 func (l LegacyApp) UnpackResources() error {
 	basePath := l.AbsPath()
 
@@ -206,11 +217,6 @@ func (l LegacyApp) UnpackResources() error {
 		return err
 	}
 
-	err = os.Remove(l.Archive)
-	if err != nil {
-		return err
-	}
-
 	err = os.Rename(
 		path.Join(basePath, "files", l.Name+".sql"),
 		path.Join(basePath, "data", l.Name+".sql"),
@@ -232,6 +238,178 @@ func (l LegacyApp) UnpackResources() error {
 	return nil
 }
 
+// PushResources packages the current docroot and a fresh mysqldump from the
+// running db container into a tar.gz with the same layout
+// GetResources/UnpackResources expect, uploads it to the configured archive
+// location, and notifies the configured channels of the outcome. Old
+// archives matching this app's prefix are pruned according to the
+// databag's retention setting.
+func (l *LegacyApp) PushResources() (err error) {
+	basePath := l.AbsPath()
+	startTime := time.Now()
+
+	dbag, err := GetDatabag(l.Name)
+	if err != nil {
+		return err
+	}
+
+	s, err := dbag.GetEnv(l.Environment)
+	if err != nil {
+		return err
+	}
+	ResolveFileSecrets(&s)
+
+	data := archiveNotification{
+		AppName:     l.Name,
+		Environment: l.Environment,
+		StartTime:   startTime,
+	}
+
+	defer func() {
+		data.EndTime = time.Now()
+		if err != nil {
+			data.Error = err.Error()
+			notify(s.NotifyURLs, s.NotifyFailureTemplate, data)
+		}
+	}()
+
+	backend := l.StorageBackend
+	if backend == nil {
+		backendURI := s.StorageBackend
+		if backendURI == "" {
+			bucket := "nmdarchive"
+			if s.AwsBucket != "" {
+				bucket = s.AwsBucket
+			}
+			backendURI = fmt.Sprintf("s3://%s", bucket)
+		}
+
+		backend, err = storage.New(backendURI)
+		if err != nil {
+			return err
+		}
+		l.StorageBackend = backend
+	}
+
+	dumpPath := path.Join(basePath, "data", l.Name+".sql")
+	// Pass the password via MYSQL_PWD rather than -proot: a password on the
+	// command line makes mysqldump print an "insecure" warning to stderr,
+	// and that warning would otherwise land as the first line of the dump.
+	out, err := utils.RunCommand(
+		"docker",
+		[]string{"exec", "-e", "MYSQL_PWD=root", l.ContainerName() + "-db", "mysqldump", "-uroot", l.Name},
+	)
+	if err != nil {
+		fmt.Println(out)
+		return err
+	}
+
+	err = ioutil.WriteFile(dumpPath, []byte(out), 0644)
+	if err != nil {
+		return err
+	}
+
+	archiveKey := fmt.Sprintf("%[1]s/%[2]s-%[1]s-%d.tar.gz", l.Name, l.Environment, startTime.Unix())
+	archivePath := path.Join(basePath, filepath.Base(archiveKey))
+
+	// Mirror the layout GetResources/UnpackResources expect: the SQL dump
+	// and docroot live at the archive root, not under data/ or src/, since
+	// UnpackResources extracts into files/ and then renames/rsyncs from
+	// there assuming that layout. Archiving src/ and files/ as "." rather
+	// than naming docroot specifically still produces that layout (docroot
+	// is a child of src/) while also preserving any other content either
+	// directory holds, instead of silently dropping it from the push.
+	out, err = utils.RunCommand(
+		"tar",
+		[]string{
+			"-czf", archivePath,
+			"-C", path.Join(basePath, "data"), l.Name + ".sql",
+			"-C", path.Join(basePath, "src"), ".",
+			"-C", path.Join(basePath, "files"), ".",
+		},
+	)
+	if err != nil {
+		fmt.Println(out)
+		return err
+	}
+	defer os.Remove(archivePath)
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = backend.Upload(archiveKey, file)
+	if err != nil {
+		return err
+	}
+
+	data.ArchiveKey = archiveKey
+	data.ArchiveBytes = info.Size()
+
+	if s.RetentionDays > 0 {
+		if pruneErr := l.pruneArchives(backend, s.RetentionDays); pruneErr != nil {
+			log.Printf("Failed to prune old archives for %s-%s: %s", l.Name, l.Environment, pruneErr)
+		}
+	}
+
+	// Send the success notification directly rather than through the named
+	// err return: the upload already succeeded, so a failure here must not
+	// also trip the deferred handler above into reporting the push failed.
+	if notifyErr := notify(s.NotifyURLs, s.NotifySuccessTemplate, data); notifyErr != nil {
+		log.Printf("Failed to send success notification for %s-%s: %s", l.Name, l.Environment, notifyErr)
+	}
+
+	return nil
+}
+
+// pruneArchives removes archives older than retentionDays that match this
+// app's archive key prefix, %[1]s/%[2]s-%[1]s-.
+func (l *LegacyApp) pruneArchives(backend storage.Backend, retentionDays int) error {
+	prefix := fmt.Sprintf("%[1]s/%[2]s-%[1]s-", l.Name, l.Environment)
+
+	objects, err := backend.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	for _, o := range objects {
+		ts, err := archiveTimestamp(o.Key, prefix)
+		if err != nil {
+			continue
+		}
+
+		if ts.Before(cutoff) {
+			if err := backend.Remove(o.Key); err != nil {
+				return err
+			}
+			log.Printf("Pruned old archive %s", o.Key)
+		}
+	}
+
+	return nil
+}
+
+// archiveTimestamp extracts the unix timestamp encoded in an archive key
+// produced by PushResources, e.g. "app/env-app-1690000000.tar.gz".
+func archiveTimestamp(key, prefix string) (time.Time, error) {
+	suffix := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".tar.gz")
+
+	sec, err := strconv.ParseInt(suffix, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(sec, 0), nil
+}
+
 // Start initiates docker-compose up
 func (l LegacyApp) Start() error {
 	basePath := l.AbsPath()
@@ -299,13 +477,14 @@ func (l *LegacyApp) Config() error {
 	if err != nil {
 		return err
 	}
+	ResolveFileSecrets(&env)
 
-	l.WebPublicPort, err = GetPodPort(l.ContainerName() + "-web")
+	l.WebPublicPort, err = portalloc.Allocate(l.ContainerName()+"-web", portalloc.WebRange.Min)
 	if err != nil {
 		return err
 	}
 
-	l.DbPublicPort, err = GetPodPort(l.ContainerName() + "-db")
+	l.DbPublicPort, err = portalloc.Allocate(l.ContainerName()+"-db", portalloc.DBRange.Min)
 	if err != nil {
 		return err
 	}
@@ -328,19 +507,21 @@ func (l *LegacyApp) Config() error {
 		}
 
 		// Setup a custom settings file for use with drush.
-		dbPort, err := GetPodPort(l.ContainerName() + "-db")
-		if err != nil {
-			return err
-		}
-
 		drushSettingsPath := path.Join(basePath, "src", "drush.settings.php")
 		drushConfig := model.NewDrushConfig()
-		drushConfig.DatabasePort = dbPort
+		drushConfig.DatabasePort = l.DbPublicPort
 		err = config.WriteDrushConfig(drushConfig, drushSettingsPath)
 
 		if err != nil {
 			log.Fatalln(err)
 		}
+
+		if env.CDNURL != "" {
+			cdnSnippetPath := path.Join(basePath, "src", "docroot", "sites", "default", "settings.local.php")
+			if err := writeCDNSettingsSnippet(cdnSnippetPath, env.CDNURL); err != nil {
+				return err
+			}
+		}
 	} else if l.AppType == "wp" {
 		log.Printf("WordPress site. Creating wp-config.php file.")
 		settingsFilePath = path.Join(basePath, "src", "docroot/wp-config.php")
@@ -359,10 +540,210 @@ func (l *LegacyApp) Config() error {
 		if err != nil {
 			log.Fatalln(err)
 		}
+
+		if env.CDNURL != "" {
+			cdnSnippetPath := path.Join(basePath, "src", "docroot", "wp-content", "mu-plugins", "ddev-cdn-uploads.php")
+			if err := writeCDNUploadsSnippet(cdnSnippetPath, env.CDNURL); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// SyncUploads incrementally syncs the WordPress uploads directory between
+// the storage backend's <app>/<env>/uploads/ prefix and
+// src/docroot/wp-content/uploads/. direction "pull" downloads only objects
+// whose size or content differs from the local file (see uploadUnchanged);
+// "push" does the reverse.
+func (l *LegacyApp) SyncUploads(direction string) error {
+	if l.AppType != "wp" {
+		return fmt.Errorf("SyncUploads is only supported for wp apps, got %q", l.AppType)
+	}
+
+	dbag, err := GetDatabag(l.Name)
+	if err != nil {
+		return err
+	}
+
+	s, err := dbag.GetEnv(l.Environment)
+	if err != nil {
+		return err
+	}
+	ResolveFileSecrets(&s)
+
+	backend := l.StorageBackend
+	if backend == nil {
+		backendURI := s.StorageBackend
+		if backendURI == "" {
+			bucket := "nmdarchive"
+			if s.AwsBucket != "" {
+				bucket = s.AwsBucket
+			}
+			backendURI = fmt.Sprintf("s3://%s", bucket)
+		}
+
+		backend, err = storage.New(backendURI)
+		if err != nil {
+			return err
+		}
+		l.StorageBackend = backend
+	}
+
+	prefix := fmt.Sprintf("%s/%s/uploads/", l.Name, l.Environment)
+	localDir := path.Join(l.AbsPath(), "src", "docroot", "wp-content", "uploads")
+
+	switch direction {
+	case "pull":
+		return pullUploads(backend, prefix, localDir)
+	case "push":
+		return pushUploads(backend, prefix, localDir)
+	default:
+		return fmt.Errorf("unknown sync direction %q, must be \"pull\" or \"push\"", direction)
+	}
+}
+
+// pullUploads downloads objects under prefix whose size or content differs
+// from the matching file under localDir, creating the file if it doesn't
+// exist yet.
+func pullUploads(backend storage.Backend, prefix, localDir string) error {
+	objects, err := backend.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range objects {
+		rel := strings.TrimPrefix(o.Key, prefix)
+		if rel == "" {
+			continue
+		}
+
+		localPath := path.Join(localDir, rel)
+		if uploadUnchanged(localPath, o) {
+			continue
+		}
+
+		if err := os.MkdirAll(path.Dir(localPath), 0755); err != nil {
+			return err
+		}
+
+		file, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+
+		err = backend.Download(o.Key, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+
+		log.Println("Pulled upload", rel)
+	}
+
+	return nil
+}
+
+// pushUploads uploads files under localDir whose size or content differs
+// from the matching object under prefix.
+func pushUploads(backend storage.Backend, prefix, localDir string) error {
+	objects, err := backend.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	remote := map[string]storage.Object{}
+	for _, o := range objects {
+		remote[strings.TrimPrefix(o.Key, prefix)] = o
+	}
+
+	return filepath.Walk(localDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+
+		if o, ok := remote[rel]; ok && uploadUnchanged(p, o) {
+			return nil
+		}
+
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if err := backend.Upload(prefix+rel, file); err != nil {
+			return err
+		}
+
+		log.Println("Pushed upload", rel)
+		return nil
+	})
+}
+
+// uploadUnchanged reports whether the file at localPath already matches o:
+// its size must match, and when o.ETag looks like a bare MD5 (as S3 and
+// most S3-compatible backends return for a non-multipart object), its
+// content hash must match too, so an in-place edit that doesn't change
+// file size is still picked up for sync.
+func uploadUnchanged(localPath string, o storage.Object) bool {
+	info, err := os.Stat(localPath)
+	if err != nil || info.Size() != o.Size {
+		return false
+	}
+
+	if !md5ETagPattern.MatchString(o.ETag) {
+		return true
+	}
+
+	hash, err := md5File(localPath)
+	return err == nil && hash == o.ETag
+}
+
+// writeCDNUploadsSnippet generates a WordPress mu-plugin whose upload_dir
+// filter points the uploads base URL at cdnURL, so dev pages still render
+// images that SyncUploads hasn't pulled down. Every upload URL is rewritten,
+// including those for files that do exist locally.
+func writeCDNUploadsSnippet(destPath, cdnURL string) error {
+	if err := os.MkdirAll(path.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	snippet := fmt.Sprintf(`<?php
+// Generated by ddev. Serves all uploads from %[1]s.
+add_filter('upload_dir', function ($dirs) {
+	$dirs['baseurl'] = '%[1]s/wp-content/uploads';
+	return $dirs;
+});
+`, cdnURL)
+
+	return ioutil.WriteFile(destPath, []byte(snippet), 0644)
+}
+
+// writeCDNSettingsSnippet generates the Drupal settings.local.php
+// equivalent, rewriting all public file URLs to cdnURL, including those for
+// files that do exist locally.
+func writeCDNSettingsSnippet(destPath, cdnURL string) error {
+	if err := os.MkdirAll(path.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	snippet := fmt.Sprintf(`<?php
+// Generated by ddev. Serves all public files from %[1]s.
+$settings['file_public_base_url'] = '%[1]s/sites/default/files';
+`, cdnURL)
+
+	return ioutil.WriteFile(destPath, []byte(snippet), 0644)
+}
+
 // Down stops the docker containers for the legacy project.
 func (l *LegacyApp) Down() error {
 	err := utils.DockerCompose(
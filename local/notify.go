@@ -0,0 +1,50 @@
+package local
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/containrrr/shoutrrr"
+)
+
+// archiveNotification is the data made available to the success/failure
+// notification templates configured for PushResources.
+type archiveNotification struct {
+	AppName      string
+	Environment  string
+	StartTime    time.Time
+	EndTime      time.Time
+	ArchiveBytes int64
+	ArchiveKey   string
+	Error        string
+}
+
+// notify renders tmpl with data and sends the result to every URL in urls.
+// Individual send failures are logged but do not stop the remaining sends,
+// since one misconfigured notification channel shouldn't mask the others.
+func notify(urls []string, tmpl string, data archiveNotification) error {
+	if len(urls) == 0 || tmpl == "" {
+		return nil
+	}
+
+	t, err := template.New("notification").Parse(tmpl)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+
+	for _, url := range urls {
+		if err := shoutrrr.Send(url, body.String()); err != nil {
+			log.Printf("Failed to send notification to %s: %s", url, err)
+		}
+	}
+
+	return nil
+}
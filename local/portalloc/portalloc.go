@@ -0,0 +1,199 @@
+// Package portalloc assigns deterministic host ports to legacy app
+// containers up front, so the generated docker-compose.yaml can declare an
+// exact port mapping instead of leaving it to compose to pick one that
+// then has to be discovered after the fact.
+package portalloc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/drud/drud-go/utils"
+)
+
+// Range bounds the ports Allocate walks through when a container's
+// preferred port, or its prior allocation, is no longer free.
+type Range struct {
+	Min int64
+	Max int64
+}
+
+var (
+	// WebRange bounds allocation for web containers.
+	WebRange = Range{Min: 8000, Max: 9000}
+	// DBRange bounds allocation for db containers.
+	DBRange = Range{Min: 13000, Max: 14000}
+)
+
+// portsPath returns ~/.drud/ports.json, the persisted name -> host port map.
+func portsPath() (string, error) {
+	homedir, err := utils.GetHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homedir, ".drud", "ports.json"), nil
+}
+
+func loadAllocations() (map[string]int64, error) {
+	p, err := portsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return map[string]int64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	allocations := map[string]int64{}
+	if err := json.Unmarshal(data, &allocations); err != nil {
+		return nil, err
+	}
+
+	return allocations, nil
+}
+
+func saveAllocations(allocations map[string]int64) error {
+	p, err := portsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(allocations)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, data, 0644)
+}
+
+var dockerPortPattern = regexp.MustCompile(`:(\d+)->`)
+
+// occupiedPortsOnce caches the result of occupiedPorts for the life of the
+// process: scanning ~2000 ports with net.Listen is expensive, and a single
+// `ddev` invocation calls Allocate several times (RenderComposeYAML and
+// Config each allocate both the web and db port) against a host whose
+// listening ports aren't changing between those calls.
+var (
+	occupiedPortsOnce   sync.Once
+	occupiedPortsResult map[int64]bool
+	occupiedPortsErr    error
+)
+
+func cachedOccupiedPorts() (map[int64]bool, error) {
+	occupiedPortsOnce.Do(func() {
+		occupiedPortsResult, occupiedPortsErr = occupiedPorts()
+	})
+	return occupiedPortsResult, occupiedPortsErr
+}
+
+// occupiedPorts builds the set of host ports already in use, combining the
+// port mappings of currently running containers with a live net.Listen
+// probe across the ranges we allocate from.
+func occupiedPorts() (map[int64]bool, error) {
+	occupied := map[int64]bool{}
+
+	out, err := utils.RunCommand("docker", []string{"ps", "--format", "{{.Ports}}"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range dockerPortPattern.FindAllStringSubmatch(out, -1) {
+		port, err := strconv.ParseInt(match[1], 10, 64)
+		if err == nil {
+			occupied[port] = true
+		}
+	}
+
+	for _, r := range []Range{WebRange, DBRange} {
+		for port := r.Min; port <= r.Max; port++ {
+			if occupied[port] {
+				continue
+			}
+
+			ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			if err != nil {
+				occupied[port] = true
+				continue
+			}
+			ln.Close()
+		}
+	}
+
+	return occupied, nil
+}
+
+func rangeFor(preferred int64) Range {
+	if preferred >= DBRange.Min && preferred <= DBRange.Max {
+		return DBRange
+	}
+	return WebRange
+}
+
+// Allocate returns a host port for name, reusing its prior allocation when
+// still free. Otherwise it walks forward from preferred within the range
+// that preferred falls in (WebRange or DBRange), wrapping back to the start
+// of the range if it reaches the end, until it finds an unclaimed port.
+func Allocate(name string, preferred int64) (int64, error) {
+	r := rangeFor(preferred)
+
+	allocations, err := loadAllocations()
+	if err != nil {
+		return 0, err
+	}
+
+	occupied, err := cachedOccupiedPorts()
+	if err != nil {
+		return 0, err
+	}
+
+	// Seed claimed with every port another app already holds, not just
+	// ports something is actually listening on yet: two apps can both be
+	// Config'd before either container starts, in which case docker ps and
+	// net.Listen agree the preferred port is free even though the other
+	// app already claimed it.
+	claimed := map[int64]bool{}
+	for port := range occupied {
+		claimed[port] = true
+	}
+	for otherName, port := range allocations {
+		if otherName != name {
+			claimed[port] = true
+		}
+	}
+
+	if port, ok := allocations[name]; ok && !claimed[port] {
+		return port, nil
+	}
+
+	for port := preferred; port <= r.Max; port++ {
+		if !claimed[port] {
+			allocations[name] = port
+			return port, saveAllocations(allocations)
+		}
+	}
+
+	for port := r.Min; port < preferred; port++ {
+		if !claimed[port] {
+			allocations[name] = port
+			return port, saveAllocations(allocations)
+		}
+	}
+
+	return 0, fmt.Errorf("no free port available in range %d-%d for %s", r.Min, r.Max, name)
+}
@@ -0,0 +1,83 @@
+package local
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// ResolveFileSecrets walks the exported string fields of the struct pointed
+// to by v and, for each empty field, looks for a path to read the secret
+// from instead of its literal value: first a sibling "<Field>File" field on
+// v itself, then (since the databag/secrets structs this is called against
+// live in github.com/drud/drud-go, outside this repo, and so can't gain
+// AwsSecretKeyFile/HashSaltFile/etc. fields of their own) a
+// "<FIELD_NAME>_FILE" environment variable. This is the _FILE convention
+// adopted by Docker secrets and docker-volume-backup, and lets CI systems
+// and docker swarm secret-based setups feed credentials like AwsSecretKey
+// or the WordPress salts into DDEV without writing them into databag JSON
+// on disk.
+//
+// It panics if both the direct and _FILE variants are set for the same
+// field, or if a referenced file cannot be read, since a misconfigured
+// secret should fail loudly at startup rather than silently fall back.
+func ResolveFileSecrets(v interface{}) {
+	elem := reflect.ValueOf(v).Elem()
+	t := elem.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() != reflect.String || strings.HasSuffix(field.Name, "File") {
+			continue
+		}
+
+		filePath, source := fileSecretPath(elem, field.Name)
+		if filePath == "" {
+			continue
+		}
+
+		direct := elem.Field(i)
+		if direct.String() != "" {
+			panic(fmt.Sprintf("both %s and %s are set; set only one", field.Name, source))
+		}
+
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			panic(fmt.Sprintf("could not read %s %q: %s", source, filePath, err))
+		}
+
+		direct.SetString(strings.TrimRight(string(data), "\n"))
+	}
+}
+
+// fileSecretPath returns the file path configured for fieldName's _FILE
+// variant, and a human-readable name for where it came from. It prefers a
+// sibling "<fieldName>File" field on elem, falling back to a
+// "<FIELD_NAME>_FILE" environment variable for structs that have no such
+// field of their own.
+func fileSecretPath(elem reflect.Value, fieldName string) (path string, source string) {
+	if fileField := elem.FieldByName(fieldName + "File"); fileField.IsValid() && fileField.Kind() == reflect.String {
+		if p := fileField.String(); p != "" {
+			return p, fieldName + "File"
+		}
+	}
+
+	envVar := screamingSnakeCase(fieldName) + "_FILE"
+	return os.Getenv(envVar), envVar
+}
+
+// screamingSnakeCase converts an identifier like "AwsAccessKey" to
+// "AWS_ACCESS_KEY".
+func screamingSnakeCase(s string) string {
+	var out []rune
+	for i, r := range s {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(s[i-1])) {
+			out = append(out, '_')
+		}
+		out = append(out, unicode.ToUpper(r))
+	}
+	return string(out)
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+// azureBackend is a Backend backed by an Azure Blob Storage container.
+// Account name and key are read from AZURE_STORAGE_ACCOUNT and
+// AZURE_STORAGE_KEY, matching the env convention used by the Azure CLI.
+type azureBackend struct {
+	container storage.Container
+}
+
+// NewAzureBackend returns a Backend for the given container.
+func NewAzureBackend(container string) (Backend, error) {
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	key := os.Getenv("AZURE_STORAGE_KEY")
+	if account == "" || key == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY must be set")
+	}
+
+	client, err := storage.NewBasicClient(account, key)
+	if err != nil {
+		return nil, err
+	}
+
+	blobClient := client.GetBlobService()
+	return &azureBackend{container: *blobClient.GetContainerReference(container)}, nil
+}
+
+func (b *azureBackend) List(prefix string) ([]Object, error) {
+	var objects []Object
+	params := storage.ListBlobsParameters{Prefix: prefix}
+	for {
+		resp, err := b.container.ListBlobs(params)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, blob := range resp.Blobs {
+			objects = append(objects, Object{
+				Key:  blob.Name,
+				Size: blob.Properties.ContentLength,
+				ETag: blob.Properties.Etag,
+			})
+		}
+
+		if resp.NextMarker == "" {
+			break
+		}
+		params.Marker = resp.NextMarker
+	}
+
+	return objects, nil
+}
+
+func (b *azureBackend) Download(key string, w io.Writer) error {
+	blob := b.container.GetBlobReference(key)
+	r, err := blob.Get(nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (b *azureBackend) Upload(key string, r io.Reader) error {
+	blob := b.container.GetBlobReference(key)
+	return blob.CreateBlockBlobFromReader(r, nil)
+}
+
+func (b *azureBackend) Remove(key string) error {
+	blob := b.container.GetBlobReference(key)
+	_, err := blob.DeleteIfExists(nil)
+	return err
+}
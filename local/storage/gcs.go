@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"io"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// gcsBackend is a Backend backed by a Google Cloud Storage bucket.
+// Credentials are resolved through the standard GOOGLE_APPLICATION_CREDENTIALS
+// environment variable.
+type gcsBackend struct {
+	bucket *storage.BucketHandle
+	ctx    context.Context
+}
+
+// NewGCSBackend returns a Backend for the given bucket.
+func NewGCSBackend(bucket string) (Backend, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcsBackend{bucket: client.Bucket(bucket), ctx: ctx}, nil
+}
+
+func (b *gcsBackend) List(prefix string) ([]Object, error) {
+	it := b.bucket.Objects(b.ctx, &storage.Query{Prefix: prefix})
+
+	var objects []Object
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, Object{
+			Key:  attrs.Name,
+			Size: attrs.Size,
+			ETag: attrs.Etag,
+		})
+	}
+
+	return objects, nil
+}
+
+func (b *gcsBackend) Download(key string, w io.Writer) error {
+	r, err := b.bucket.Object(key).NewReader(b.ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (b *gcsBackend) Upload(key string, r io.Reader) error {
+	w := b.bucket.Object(key).NewWriter(b.ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (b *gcsBackend) Remove(key string) error {
+	return b.bucket.Object(key).Delete(b.ctx)
+}
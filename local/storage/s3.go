@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Backend is a Backend backed by an AWS S3 bucket. Credentials are taken
+// from the standard AWS environment variables / instance profile chain, so
+// callers that need to inject static credentials should set
+// AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY before calling New.
+type s3Backend struct {
+	bucket string
+	region string
+}
+
+// NewS3Backend returns a Backend for the given bucket in us-west-2, the
+// region the legacy archive bucket has always lived in.
+func NewS3Backend(bucket string) Backend {
+	return &s3Backend{bucket: bucket, region: "us-west-2"}
+}
+
+func (b *s3Backend) session() *session.Session {
+	return session.New(&aws.Config{Region: aws.String(b.region)})
+}
+
+func (b *s3Backend) List(prefix string) ([]Object, error) {
+	svc := s3.New(b.session())
+
+	var objects []Object
+	err := svc.ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, o := range page.Contents {
+			objects = append(objects, Object{
+				Key:  *o.Key,
+				Size: *o.Size,
+				ETag: strings.Trim(*o.ETag, `"`),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+func (b *s3Backend) Download(key string, w io.Writer) error {
+	svc := s3.New(b.session())
+
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (b *s3Backend) Upload(key string, r io.Reader) error {
+	uploader := s3manager.NewUploader(b.session())
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	})
+	return err
+}
+
+func (b *s3Backend) Remove(key string) error {
+	svc := s3.New(b.session())
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
@@ -0,0 +1,115 @@
+// Package storage provides a pluggable interface for the archive storage
+// locations that legacy apps pull their SQL/code snapshots from and push
+// backups back to.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Object describes a single object found in a storage backend, as returned
+// by List.
+type Object struct {
+	Key  string
+	Size int64
+	ETag string
+}
+
+// Backend is implemented by each supported archive storage location. The
+// same interface is used whether the archive actually lives in S3, Azure
+// Blob, Google Cloud Storage, on the local filesystem, or behind SFTP.
+type Backend interface {
+	// List returns the objects whose key begins with prefix.
+	List(prefix string) ([]Object, error)
+	// Download streams the object at key into w.
+	Download(key string, w io.Writer) error
+	// Upload streams r into the object at key.
+	Upload(key string, r io.Reader) error
+	// Remove deletes the object at key.
+	Remove(key string) error
+}
+
+// New resolves a Backend from a URI. The scheme selects the implementation:
+//
+//	s3://bucket/prefix
+//	azure://container/prefix
+//	gs://bucket/prefix
+//	file:///absolute/path
+//	sftp://user@host:port/path
+func New(uri string) (Backend, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage backend %q: %s", uri, err)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return withPrefix(NewS3Backend(u.Host), u.Path), nil
+	case "azure":
+		b, err := NewAzureBackend(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return withPrefix(b, u.Path), nil
+	case "gs":
+		b, err := NewGCSBackend(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return withPrefix(b, u.Path), nil
+	case "file":
+		return NewFileBackend(u.Path), nil
+	case "sftp":
+		return NewSFTPBackend(u)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend scheme %q", u.Scheme)
+	}
+}
+
+// withPrefix wraps b so every key is transparently scoped under rawPath,
+// honoring a configured sub-path like s3://bucket/team-prefix instead of
+// silently operating against the bucket root.
+func withPrefix(b Backend, rawPath string) Backend {
+	prefix := strings.Trim(rawPath, "/")
+	if prefix == "" {
+		return b
+	}
+
+	return &prefixedBackend{inner: b, prefix: prefix + "/"}
+}
+
+// prefixedBackend scopes all keys passed to inner under prefix, and strips
+// prefix back off keys returned by List so callers keep working with
+// paths relative to the configured backend root.
+type prefixedBackend struct {
+	inner  Backend
+	prefix string
+}
+
+func (p *prefixedBackend) List(prefix string) ([]Object, error) {
+	objects, err := p.inner.List(p.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range objects {
+		objects[i].Key = strings.TrimPrefix(objects[i].Key, p.prefix)
+	}
+
+	return objects, nil
+}
+
+func (p *prefixedBackend) Download(key string, w io.Writer) error {
+	return p.inner.Download(p.prefix+key, w)
+}
+
+func (p *prefixedBackend) Upload(key string, r io.Reader) error {
+	return p.inner.Upload(p.prefix+key, r)
+}
+
+func (p *prefixedBackend) Remove(key string) error {
+	return p.inner.Remove(p.prefix + key)
+}